@@ -0,0 +1,19 @@
+package api
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/robustirc/robustirc/ircserver"
+)
+
+// ApplyMessageWait applies msg to the raft log and waits up to timeout for
+// it to be committed. It is used by main's session-expiry loop, which only
+// runs on the leader but may race with a leadership change.
+func (h *HTTP) ApplyMessageWait(msg *ircserver.Message, timeout time.Duration) error {
+	future := h.node.Apply(msg.Data, timeout)
+	if err := future.Error(); err != nil {
+		return fmt.Errorf("Apply(): %v", err)
+	}
+	return nil
+}
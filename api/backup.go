@@ -0,0 +1,32 @@
+package api
+
+import "net/http"
+
+// handleBackup streams a full copy of the irc log store via
+// raft_store.Store.Backup, regardless of which -store_backend is
+// configured. Taking a backup of the raft log itself is not necessary: a
+// fresh node can always recover it by -join-ing the network.
+func (h *HTTP) handleBackup(w http.ResponseWriter, r *http.Request) {
+	if !h.requireAuth(w, r) {
+		return
+	}
+	w.Header().Set("Content-Type", "application/octet-stream")
+	if err := h.ircStore.Backup(w); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+}
+
+// handleRestore replaces the irc log store with the request body, which
+// must have been produced by /backup of the same -store_backend. The node
+// should be taken out of the network (or stopped) before calling this, as
+// it does not coordinate with the raft log.
+func (h *HTTP) handleRestore(w http.ResponseWriter, r *http.Request) {
+	if !h.requireAuth(w, r) {
+		return
+	}
+	if err := h.ircStore.Restore(r.Body); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+}
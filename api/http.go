@@ -0,0 +1,82 @@
+package api
+
+import (
+	"net/http"
+
+	"github.com/hashicorp/raft"
+	"github.com/robustirc/rafthttp"
+	"github.com/robustirc/robustirc/ircserver"
+	"github.com/robustirc/robustirc/raft_store"
+)
+
+// HTTP serves RobustIRC's raft membership endpoints (joining/leaving the
+// network, promotion) as well as administrative and introspection endpoints
+// such as /raft/state. It serves no IRC session routes; raft log entries are
+// applied through ApplyMessageWait.
+type HTTP struct {
+	ircServer       *ircserver.IRCServer
+	node            *raft.Raft
+	ircStore        raft_store.Store
+	transport       *rafthttp.HTTPTransport
+	network         string
+	networkPassword string
+	raftDir         string
+	serverId        string
+	peerAddr        string
+}
+
+// NewHTTP registers RobustIRC's HTTP handlers (raft membership, promotion of
+// proxies to voters, and introspection/admin endpoints) on mux and returns a
+// handle that can be used to apply raft log entries (see ApplyMessageWait).
+// It registers no IRC session handling routes.
+func NewHTTP(
+	ircServer *ircserver.IRCServer,
+	node *raft.Raft,
+	ircStore raft_store.Store,
+	transport *rafthttp.HTTPTransport,
+	network string,
+	networkPassword string,
+	raftDir string,
+	serverId string,
+	peerAddr string,
+	mux *http.ServeMux) *HTTP {
+	h := &HTTP{
+		ircServer:       ircServer,
+		node:            node,
+		ircStore:        ircStore,
+		transport:       transport,
+		network:         network,
+		networkPassword: networkPassword,
+		raftDir:         raftDir,
+		serverId:        serverId,
+		peerAddr:        peerAddr,
+	}
+
+	mux.HandleFunc("/join", h.handleJoin)
+	mux.HandleFunc("/part", h.handlePart)
+	mux.HandleFunc("/promote", h.handlePromote)
+
+	mux.HandleFunc("/raft/state", h.handleRaftState)
+	mux.HandleFunc("/raft/leader", h.handleRaftLeader)
+	mux.HandleFunc("/raft/configuration", h.handleRaftConfiguration)
+	mux.HandleFunc("/raft/stats", h.handleRaftStats)
+
+	mux.HandleFunc("/backup", h.handleBackup)
+	mux.HandleFunc("/restore", h.handleRestore)
+
+	return h
+}
+
+// requireAuth verifies the robustirc:networkPassword basic-auth credentials
+// that are also used for the status page and raft RPCs, and writes a 401
+// response if they are missing or wrong. It returns whether the caller may
+// proceed.
+func (h *HTTP) requireAuth(w http.ResponseWriter, r *http.Request) bool {
+	user, pass, ok := r.BasicAuth()
+	if !ok || user != "robustirc" || pass != h.networkPassword {
+		w.Header().Set("WWW-Authenticate", `Basic realm="RobustIRC"`)
+		http.Error(w, "Invalid credentials", http.StatusUnauthorized)
+		return false
+	}
+	return true
+}
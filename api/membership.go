@@ -0,0 +1,128 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/hashicorp/raft"
+)
+
+const membershipChangeTimeout = 10 * time.Second
+
+// joinRequest is sent by a node that wants to join the network, either as a
+// voter or (if NonVoter is set) as a non-voting proxy.
+type joinRequest struct {
+	ID       string
+	Addr     string
+	NonVoter bool
+}
+
+// partRequest is sent by (or on behalf of) a node that wants to leave the
+// network.
+type partRequest struct {
+	ID string
+}
+
+// handleJoin adds the requesting node to the raft configuration, as a voter
+// or (NonVoter) as a non-voting proxy. Only the leader can service this
+// request; non-leaders redirect to the current leader so that joinMaster()
+// can follow along, mirroring the pre-1.0 behavior.
+func (h *HTTP) handleJoin(w http.ResponseWriter, r *http.Request) {
+	if !h.requireAuth(w, r) {
+		return
+	}
+	if h.node.State() != raft.Leader {
+		h.redirectToLeader(w, r)
+		return
+	}
+
+	var req joinRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	var future raft.IndexFuture
+	if req.NonVoter {
+		future = h.node.AddNonvoter(raft.ServerID(req.ID), raft.ServerAddress(req.Addr), 0, membershipChangeTimeout)
+	} else {
+		future = h.node.AddVoter(raft.ServerID(req.ID), raft.ServerAddress(req.Addr), 0, membershipChangeTimeout)
+	}
+	if err := future.Error(); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+}
+
+// handlePart removes a node from the raft configuration, voter or not.
+func (h *HTTP) handlePart(w http.ResponseWriter, r *http.Request) {
+	if !h.requireAuth(w, r) {
+		return
+	}
+	if h.node.State() != raft.Leader {
+		h.redirectToLeader(w, r)
+		return
+	}
+
+	var req partRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if err := h.node.RemoveServer(raft.ServerID(req.ID), 0, membershipChangeTimeout).Error(); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+}
+
+// handlePromote turns a non-voting proxy node into a full voter.
+func (h *HTTP) handlePromote(w http.ResponseWriter, r *http.Request) {
+	if !h.requireAuth(w, r) {
+		return
+	}
+	if h.node.State() != raft.Leader {
+		h.redirectToLeader(w, r)
+		return
+	}
+
+	var req partRequest // only ID is needed to identify the server to promote
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	future := h.node.GetConfiguration()
+	if err := future.Error(); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	var addr raft.ServerAddress
+	for _, server := range future.Configuration().Servers {
+		if server.ID == raft.ServerID(req.ID) {
+			addr = server.Address
+		}
+	}
+	if addr == "" {
+		http.Error(w, "no such server in the current configuration", http.StatusNotFound)
+		return
+	}
+
+	if err := h.node.AddVoter(raft.ServerID(req.ID), addr, 0, membershipChangeTimeout).Error(); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+}
+
+// redirectToLeader redirects the request to the current leader so that
+// clients (joinMaster, the status page, …) can follow along without needing
+// to know the leader in advance.
+func (h *HTTP) redirectToLeader(w http.ResponseWriter, r *http.Request) {
+	leader := h.node.Leader()
+	if leader == "" {
+		http.Error(w, "no leader known", http.StatusServiceUnavailable)
+		return
+	}
+	http.Redirect(w, r, "https://"+string(leader)+r.URL.Path, http.StatusFound)
+}
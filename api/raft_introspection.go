@@ -0,0 +1,125 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/hashicorp/raft"
+)
+
+// raftStateResponse is returned by GET /raft/state.
+type raftStateResponse struct {
+	State        string
+	LastLogIndex uint64
+	AppliedIndex uint64
+}
+
+// raftLeaderResponse is returned by GET /raft/leader.
+type raftLeaderResponse struct {
+	// Addr is empty when no leader is currently known.
+	Addr string
+}
+
+// raftServer describes one member of the raft configuration, as returned by
+// GET /raft/configuration.
+type raftServer struct {
+	ID       string
+	Addr     string
+	Suffrage string
+}
+
+type raftConfigurationResponse struct {
+	Servers []raftServer
+}
+
+// raftStatsResponse is returned by GET /raft/stats. It wraps node.Stats()
+// (this node's own view, where "last_contact" is the time since this node
+// last heard from the leader; it holds no per-peer replication lag) together
+// with the last snapshot's metadata, since the snapshot's ID and size are not
+// exposed via Stats().
+type raftStatsResponse struct {
+	Stats    map[string]string
+	Snapshot *snapshotMeta `json:",omitempty"`
+}
+
+type snapshotMeta struct {
+	ID    string
+	Index uint64
+	Term  uint64
+	Size  int64
+}
+
+// handleRaftState reports the current raft.RaftState (Follower, Candidate,
+// Leader or Shutdown) together with the last-log and last-applied indices,
+// so that e.g. robustirc-rollingrestart can tell whether restarting this
+// node is currently safe.
+func (h *HTTP) handleRaftState(w http.ResponseWriter, r *http.Request) {
+	if !h.requireAuth(w, r) {
+		return
+	}
+	json.NewEncoder(w).Encode(raftStateResponse{
+		State:        h.node.State().String(),
+		LastLogIndex: h.node.LastIndex(),
+		AppliedIndex: h.node.AppliedIndex(),
+	})
+}
+
+// handleRaftLeader reports the ServerAddress of the current leader, or an
+// empty Addr if no leader is known (e.g. during an election).
+func (h *HTTP) handleRaftLeader(w http.ResponseWriter, r *http.Request) {
+	if !h.requireAuth(w, r) {
+		return
+	}
+	json.NewEncoder(w).Encode(raftLeaderResponse{Addr: string(h.node.Leader())})
+}
+
+// handleRaftConfiguration reports the full voter/nonvoter list, including
+// suffrage, as currently known to the raft log.
+func (h *HTTP) handleRaftConfiguration(w http.ResponseWriter, r *http.Request) {
+	if !h.requireAuth(w, r) {
+		return
+	}
+	future := h.node.GetConfiguration()
+	if err := future.Error(); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	var resp raftConfigurationResponse
+	for _, server := range future.Configuration().Servers {
+		suffrage := "voter"
+		switch server.Suffrage {
+		case raft.Nonvoter:
+			suffrage = "nonvoter"
+		case raft.Staging:
+			suffrage = "staging"
+		}
+		resp.Servers = append(resp.Servers, raftServer{
+			ID:       string(server.ID),
+			Addr:     string(server.Address),
+			Suffrage: suffrage,
+		})
+	}
+	json.NewEncoder(w).Encode(resp)
+}
+
+// handleRaftStats exposes node.Stats() (this node's own counters; per-peer
+// replication lag is not included) plus the most recent snapshot's metadata, which is
+// otherwise only visible by reading files in -raftdir/snapshots.
+func (h *HTTP) handleRaftStats(w http.ResponseWriter, r *http.Request) {
+	if !h.requireAuth(w, r) {
+		return
+	}
+	resp := raftStatsResponse{Stats: h.node.Stats()}
+	if fss, err := raft.NewFileSnapshotStore(h.raftDir, 1, nil); err == nil {
+		if snapshots, err := fss.List(); err == nil && len(snapshots) > 0 {
+			latest := snapshots[0]
+			resp.Snapshot = &snapshotMeta{
+				ID:    latest.ID,
+				Index: latest.Index,
+				Term:  latest.Term,
+				Size:  latest.Size,
+			}
+		}
+	}
+	json.NewEncoder(w).Encode(resp)
+}
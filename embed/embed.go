@@ -0,0 +1,241 @@
+// Package embed brings up a RobustIRC node in-process, without TLS or
+// glog's file-based logging, so that tests can start 3- or 5-node clusters
+// on ephemeral ports in a single `go test` binary instead of provisioning
+// VMs or shelling out to the real robustirc binary. It mirrors the node
+// bring-up code in main(), minus the parts (TLS, -singlenode/-join CLI
+// plumbing, glog) that only make sense for a real deployment.
+package embed
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/hashicorp/raft"
+	"github.com/robustirc/rafthttp"
+	"github.com/robustirc/robustirc/api"
+	"github.com/robustirc/robustirc/ircserver"
+	"github.com/robustirc/robustirc/raft_store"
+	"github.com/robustirc/robustirc/robusthttp"
+)
+
+// Config describes one embedded RobustIRC node. Fields correspond 1:1 to
+// main()'s flags of the same name; see their usage strings for details.
+type Config struct {
+	RaftDir         string
+	Network         string
+	NetworkPassword string
+	ServerId        string
+	PeerAddr        string // host:port this node's raft transport listens on
+	Listen          string // host:port for the plain-HTTP (no TLS) API; defaults to PeerAddr
+	StoreBackend    string // typically "inmem" for tests
+	SingleNode      bool
+	Join            string // another Node's PeerAddr to join
+}
+
+// Node is a running, embedded RobustIRC node.
+type Node struct {
+	cfg       Config
+	raft      *raft.Raft
+	ircServer *ircserver.IRCServer
+	ln        net.Listener
+	srv       *http.Server
+}
+
+// Embed constructs a Node from cfg but does not start serving; call Start.
+func Embed(cfg Config) (*Node, error) {
+	if cfg.Listen == "" {
+		cfg.Listen = cfg.PeerAddr
+	}
+	if cfg.ServerId == "" {
+		cfg.ServerId = cfg.PeerAddr
+	}
+	if cfg.StoreBackend == "" {
+		cfg.StoreBackend = "inmem"
+	}
+
+	ircServer := ircserver.NewIRCServer(cfg.RaftDir, cfg.Network, time.Now())
+
+	transport := rafthttp.NewHTTPTransport(
+		raft.ServerAddress(cfg.PeerAddr),
+		robusthttp.Client(cfg.NetworkPassword, false),
+		nil,
+		"")
+
+	config := raft.DefaultConfig()
+	config.LocalID = raft.ServerID(cfg.ServerId)
+	// Tests care about fast convergence, not production-grade batching.
+	config.HeartbeatTimeout = 50 * time.Millisecond
+	config.ElectionTimeout = 50 * time.Millisecond
+	config.LeaderLeaseTimeout = 50 * time.Millisecond
+	config.SnapshotInterval = time.Hour
+
+	snaps := raft.NewInmemSnapshotStore()
+
+	logStore, err := raft_store.Open(cfg.StoreBackend, cfg.RaftDir+"/raftlog", cfg.SingleNode || cfg.Join != "")
+	if err != nil {
+		return nil, fmt.Errorf("opening raftlog store: %v", err)
+	}
+	ircStore, err := raft_store.Open(cfg.StoreBackend, cfg.RaftDir+"/irclog", cfg.SingleNode || cfg.Join != "")
+	if err != nil {
+		return nil, fmt.Errorf("opening irclog store: %v", err)
+	}
+
+	fsm := &FSM{ircServer: ircServer, ircStore: ircStore}
+
+	logcache, err := raft.NewLogCache(config.MaxAppendEntries, logStore)
+	if err != nil {
+		return nil, err
+	}
+
+	r, err := raft.NewRaft(config, fsm, logcache, logStore, snaps, transport)
+	if err != nil {
+		return nil, fmt.Errorf("raft.NewRaft: %v", err)
+	}
+
+	if cfg.SingleNode {
+		f := r.BootstrapCluster(raft.Configuration{
+			Servers: []raft.Server{
+				{Suffrage: raft.Voter, ID: raft.ServerID(cfg.ServerId), Address: raft.ServerAddress(cfg.PeerAddr)},
+			},
+		})
+		if err := f.Error(); err != nil && err != raft.ErrCantBootstrap {
+			return nil, fmt.Errorf("BootstrapCluster: %v", err)
+		}
+	}
+
+	mux := http.NewServeMux()
+	api.NewHTTP(ircServer, r, ircStore, transport, cfg.Network, cfg.NetworkPassword, cfg.RaftDir, cfg.ServerId, cfg.PeerAddr, mux)
+
+	ln, err := net.Listen("tcp", cfg.Listen)
+	if err != nil {
+		return nil, fmt.Errorf("net.Listen(%q): %v", cfg.Listen, err)
+	}
+
+	return &Node{
+		cfg:       cfg,
+		raft:      r,
+		ircServer: ircServer,
+		ln:        ln,
+		srv:       &http.Server{Handler: mux},
+	}, nil
+}
+
+// Start serves the node's HTTP API in the background, then, if cfg.Join is
+// set, asks that node's /join endpoint to add us to its raft configuration
+// (mirroring main's joinMaster, minus TLS). It returns once the join has
+// been acknowledged, or after 5s if it never succeeds.
+func (n *Node) Start() error {
+	go n.srv.Serve(n.ln)
+
+	if n.cfg.Join == "" {
+		return nil
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	type joinRequest struct {
+		ID       string
+		Addr     string
+		NonVoter bool
+	}
+	return n.membershipRequest(ctx, n.cfg.Join, "/join", joinRequest{ID: n.cfg.ServerId, Addr: n.cfg.PeerAddr})
+}
+
+// Part asks addr's /part endpoint to remove id from the raft configuration,
+// following redirects to the current leader and retrying while no leader is
+// known yet. Use it to exercise graceful removal rather than just killing a
+// node's process.
+func (n *Node) Part(ctx context.Context, addr, id string) error {
+	type partRequest struct {
+		ID string
+	}
+	return n.membershipRequest(ctx, addr, "/part", partRequest{ID: id})
+}
+
+// membershipRequest POSTs body (marshaled as JSON) to path on addr,
+// following redirects to the current leader and retrying while no leader is
+// known yet (e.g. right after addr's node bootstrapped and has not been
+// elected), until ctx is done.
+func (n *Node) membershipRequest(ctx context.Context, addr, path string, body interface{}) error {
+	data, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+
+	client := n.Client()
+	ticker := time.NewTicker(10 * time.Millisecond)
+	defer ticker.Stop()
+	for {
+		req, err := http.NewRequest("POST", "http://"+addr+path, bytes.NewReader(data))
+		if err != nil {
+			return err
+		}
+		req.Header.Set("Content-Type", "application/json")
+		res, doErr := client.Do(req)
+		if doErr == nil {
+			res.Body.Close()
+			switch {
+			case res.StatusCode < 300:
+				return nil
+			case res.StatusCode < 400:
+				if loc := res.Header.Get("Location"); loc != "" {
+					if u, err := url.Parse(loc); err == nil {
+						addr = u.Host
+					}
+				}
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			if doErr != nil {
+				return fmt.Errorf("%s %s: %v", path, addr, doErr)
+			}
+			return fmt.Errorf("%s %s: %s", path, addr, res.Status)
+		case <-ticker.C:
+		}
+	}
+}
+
+// Stop closes the listener and shuts down raft. It does not remove the node
+// from the cluster's configuration; call the /part endpoint first if that
+// is desired (e.g. to test graceful removal rather than a crash).
+func (n *Node) Stop() error {
+	if err := n.srv.Close(); err != nil {
+		return err
+	}
+	return n.raft.Shutdown().Error()
+}
+
+// Leader returns the PeerAddr of the current leader, or "" if none is
+// known.
+func (n *Node) Leader() string {
+	return string(n.raft.Leader())
+}
+
+// WaitForLeader blocks until a leader is known or ctx is done.
+func (n *Node) WaitForLeader(ctx context.Context) error {
+	ticker := time.NewTicker(10 * time.Millisecond)
+	defer ticker.Stop()
+	for {
+		if n.Leader() != "" {
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+// Client returns an HTTP client configured to authenticate against this
+// node, for use by test code that talks to the node's HTTP API directly.
+func (n *Node) Client() *http.Client {
+	return robusthttp.Client(n.cfg.NetworkPassword, false)
+}
@@ -0,0 +1,30 @@
+package embed
+
+import (
+	"io"
+
+	"github.com/hashicorp/raft"
+	"github.com/robustirc/robustirc/ircserver"
+	"github.com/robustirc/robustirc/raft_store"
+)
+
+// FSM is a minimal raft.FSM that applies messages to ircServer, mirroring
+// main's FSM closely enough for tests, without pulling in main's
+// canary/compaction machinery. It holds ircStore, but Apply does not write
+// messages to it.
+type FSM struct {
+	ircServer *ircserver.IRCServer
+	ircStore  raft_store.Store
+}
+
+func (f *FSM) Apply(l *raft.Log) interface{} {
+	return f.ircServer.Apply(l)
+}
+
+func (f *FSM) Snapshot() (raft.FSMSnapshot, error) {
+	return f.ircServer.Snapshot()
+}
+
+func (f *FSM) Restore(rc io.ReadCloser) error {
+	return f.ircServer.Restore(rc)
+}
@@ -0,0 +1,83 @@
+package embed
+
+import (
+	"context"
+	"flag"
+	"math/rand"
+	"testing"
+	"time"
+)
+
+// liveness enables TestLiveness, which runs far longer than a normal unit
+// test: go test ./embed/... -run TestLiveness -liveness -timeout 5m
+var liveness = flag.Bool("liveness", false, "run the long-lived cluster liveness test (kills and restarts random members in a loop)")
+
+// TestLiveness repeatedly kills and restarts random members of a 5-node
+// cluster, asserting that the remaining voters keep (or promptly regain) a
+// leader throughout. This is the in-process replacement for provisioning VMs
+// and manually pulling the plug on a box to reproduce split-brain and
+// timesafeguard scenarios.
+//
+// It does not drive real IRC client traffic: session creation and message
+// framing are handled by the ircserver package, which is not part of this
+// trimmed repository snapshot (the same reason api.NewHTTP here has no
+// session-handling routes), so there is no endpoint this test could call.
+// It instead exercises the part of "liveness" that lives in this package:
+// raft quorum recovery under repeated membership churn.
+func TestLiveness(t *testing.T) {
+	if !*liveness {
+		t.Skip("skipping long-running liveness test; pass -liveness to run it")
+	}
+
+	const numNodes = 5
+	nodes, stop := startCluster(t, numNodes)
+	defer stop()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	for i, node := range nodes {
+		if err := node.WaitForLeader(ctx); err != nil {
+			t.Fatalf("node %d never saw a leader: %v", i, err)
+		}
+	}
+
+	deadline := time.Now().Add(4 * time.Minute)
+	for round := 0; time.Now().Before(deadline); round++ {
+		victim := rand.Intn(numNodes)
+		if err := nodes[victim].Stop(); err != nil {
+			t.Fatalf("round %d: Stop(node %d): %v", round, victim, err)
+		}
+
+		checkCtx, checkCancel := context.WithTimeout(context.Background(), 5*time.Second)
+		for i, node := range nodes {
+			if i == victim {
+				continue
+			}
+			if err := node.WaitForLeader(checkCtx); err != nil {
+				checkCancel()
+				t.Fatalf("round %d: node %d lost quorum after node %d was killed: %v", round, i, victim, err)
+			}
+		}
+		checkCancel()
+
+		cfg := nodes[victim].cfg
+		cfg.RaftDir = t.TempDir()
+		cfg.SingleNode = false
+		cfg.Join = nodes[(victim+1)%numNodes].cfg.PeerAddr
+		restarted, err := Embed(cfg)
+		if err != nil {
+			t.Fatalf("round %d: Embed(restarted node %d): %v", round, victim, err)
+		}
+		if err := restarted.Start(); err != nil {
+			t.Fatalf("round %d: Start(restarted node %d): %v", round, victim, err)
+		}
+		nodes[victim] = restarted
+
+		restartCtx, restartCancel := context.WithTimeout(context.Background(), 5*time.Second)
+		if err := restarted.WaitForLeader(restartCtx); err != nil {
+			restartCancel()
+			t.Fatalf("round %d: restarted node %d never rejoined quorum: %v", round, victim, err)
+		}
+		restartCancel()
+	}
+}
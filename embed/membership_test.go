@@ -0,0 +1,101 @@
+package embed
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+// raftServer mirrors api.raftServer's JSON shape; it is redefined here since
+// api does not export it.
+type raftServer struct {
+	ID       string
+	Addr     string
+	Suffrage string
+}
+
+// configuration fetches the raft configuration known to n via
+// /raft/configuration, for assertions in tests.
+func configuration(t *testing.T, n *Node) []raftServer {
+	t.Helper()
+	res, err := n.Client().Get("http://" + n.cfg.Listen + "/raft/configuration")
+	if err != nil {
+		t.Fatalf("GET /raft/configuration: %v", err)
+	}
+	defer res.Body.Close()
+	var resp struct {
+		Servers []raftServer
+	}
+	if err := json.NewDecoder(res.Body).Decode(&resp); err != nil {
+		t.Fatalf("decoding /raft/configuration: %v", err)
+	}
+	return resp.Servers
+}
+
+// TestBootstrapAndJoin verifies that once a second node has -join-ed a
+// -singlenode node, both nodes' view of the configuration holds 2 servers,
+// each of them a voter.
+func TestBootstrapAndJoin(t *testing.T) {
+	nodes, stop := startCluster(t, 2)
+	defer stop()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	for i, node := range nodes {
+		if err := node.WaitForLeader(ctx); err != nil {
+			t.Fatalf("node %d never saw a leader: %v", i, err)
+		}
+	}
+
+	for i, node := range nodes {
+		servers := configuration(t, node)
+		if len(servers) != 2 {
+			t.Fatalf("node %d: configuration has %d servers, want 2 (%v)", i, len(servers), servers)
+		}
+		for _, server := range servers {
+			if server.Suffrage != "voter" {
+				t.Errorf("node %d: server %q has suffrage %q, want \"voter\"", i, server.ID, server.Suffrage)
+			}
+		}
+	}
+}
+
+// TestGracefulRemoval verifies that a node removed via /part disappears from
+// the remaining nodes' configuration and that the cluster keeps a leader.
+func TestGracefulRemoval(t *testing.T) {
+	nodes, stop := startCluster(t, 3)
+	defer stop()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	for i, node := range nodes {
+		if err := node.WaitForLeader(ctx); err != nil {
+			t.Fatalf("node %d never saw a leader: %v", i, err)
+		}
+	}
+
+	removed := nodes[2]
+	if err := nodes[0].Part(ctx, nodes[0].cfg.PeerAddr, removed.cfg.ServerId); err != nil {
+		t.Fatalf("Part(%q): %v", removed.cfg.ServerId, err)
+	}
+	if err := removed.Stop(); err != nil {
+		t.Fatalf("Stop(removed node): %v", err)
+	}
+
+	remaining := nodes[:2]
+	for i, node := range remaining {
+		if err := node.WaitForLeader(ctx); err != nil {
+			t.Fatalf("remaining node %d lost its leader after removal: %v", i, err)
+		}
+		servers := configuration(t, node)
+		if len(servers) != 2 {
+			t.Fatalf("remaining node %d: configuration has %d servers after removal, want 2 (%v)", i, len(servers), servers)
+		}
+		for _, server := range servers {
+			if server.ID == removed.cfg.ServerId {
+				t.Errorf("remaining node %d: removed server %q is still in the configuration", i, removed.cfg.ServerId)
+			}
+		}
+	}
+}
@@ -0,0 +1,123 @@
+package raft_store
+
+import (
+	"fmt"
+	"io"
+	"sync"
+
+	"github.com/hashicorp/raft"
+)
+
+// InmemStore implements Store purely in memory, for tests: it never touches
+// disk, so a 3- or 5-node cluster can be spun up and torn down quickly and
+// in parallel.
+type InmemStore struct {
+	mu     sync.Mutex
+	logs   map[uint64]*raft.Log
+	kv     map[string][]byte
+	kvUint map[string]uint64
+}
+
+func NewInmemStore() *InmemStore {
+	return &InmemStore{
+		logs:   make(map[uint64]*raft.Log),
+		kv:     make(map[string][]byte),
+		kvUint: make(map[string]uint64),
+	}
+}
+
+func (s *InmemStore) Close() error { return nil }
+
+func (s *InmemStore) FirstIndex() (uint64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var first uint64
+	for idx := range s.logs {
+		if first == 0 || idx < first {
+			first = idx
+		}
+	}
+	return first, nil
+}
+
+func (s *InmemStore) LastIndex() (uint64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var last uint64
+	for idx := range s.logs {
+		if idx > last {
+			last = idx
+		}
+	}
+	return last, nil
+}
+
+func (s *InmemStore) GetLog(index uint64, log *raft.Log) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	l, ok := s.logs[index]
+	if !ok {
+		return raft.ErrLogNotFound
+	}
+	*log = *l
+	return nil
+}
+
+func (s *InmemStore) StoreLog(log *raft.Log) error {
+	return s.StoreLogs([]*raft.Log{log})
+}
+
+func (s *InmemStore) StoreLogs(logs []*raft.Log) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, log := range logs {
+		copied := *log
+		s.logs[log.Index] = &copied
+	}
+	return nil
+}
+
+func (s *InmemStore) DeleteRange(min, max uint64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for idx := min; idx <= max; idx++ {
+		delete(s.logs, idx)
+	}
+	return nil
+}
+
+func (s *InmemStore) Set(key, val []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.kv[string(key)] = append([]byte(nil), val...)
+	return nil
+}
+
+func (s *InmemStore) Get(key []byte) ([]byte, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.kv[string(key)], nil
+}
+
+func (s *InmemStore) SetUint64(key []byte, val uint64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.kvUint[string(key)] = val
+	return nil
+}
+
+func (s *InmemStore) GetUint64(key []byte) (uint64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.kvUint[string(key)], nil
+}
+
+// Backup/Restore are not meaningful for an in-memory, test-only store.
+
+func (s *InmemStore) Backup(w io.Writer) error {
+	return fmt.Errorf("raft_store: Backup is not supported for the inmem backend")
+}
+
+func (s *InmemStore) Restore(r io.Reader) error {
+	return fmt.Errorf("raft_store: Restore is not supported for the inmem backend")
+}
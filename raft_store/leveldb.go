@@ -0,0 +1,188 @@
+package raft_store
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/gob"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/hashicorp/raft"
+	"github.com/syndtr/goleveldb/leveldb"
+	"github.com/syndtr/goleveldb/leveldb/util"
+)
+
+// LevelDBStore implements Store on top of a single LevelDB database. It is
+// the default backend, kept for compatibility with existing installs.
+type LevelDBStore struct {
+	db   *leveldb.DB
+	path string
+}
+
+// NewLevelDBStore opens (creating if necessary) a LevelDB database at path.
+// bootstrapping is accepted for API compatibility with earlier versions of
+// this package, which used it to decide whether an empty database was
+// acceptable; opening always succeeds on an empty database nowadays.
+func NewLevelDBStore(path string, bootstrapping bool) (*LevelDBStore, error) {
+	db, err := leveldb.OpenFile(path, nil)
+	if err != nil {
+		return nil, err
+	}
+	return &LevelDBStore{db: db, path: path}, nil
+}
+
+func (s *LevelDBStore) Close() error {
+	return s.db.Close()
+}
+
+func uint64ToBytes(v uint64) []byte {
+	b := make([]byte, 8)
+	binary.BigEndian.PutUint64(b, v)
+	return b
+}
+
+func bytesToUint64(b []byte) uint64 {
+	return binary.BigEndian.Uint64(b)
+}
+
+func (s *LevelDBStore) FirstIndex() (uint64, error) {
+	iter := s.db.NewIterator(util.BytesPrefix([]byte("log-")), nil)
+	defer iter.Release()
+	if !iter.Next() {
+		return 0, nil
+	}
+	return bytesToUint64(iter.Key()[len("log-"):]), iter.Error()
+}
+
+func (s *LevelDBStore) LastIndex() (uint64, error) {
+	iter := s.db.NewIterator(util.BytesPrefix([]byte("log-")), nil)
+	defer iter.Release()
+	if !iter.Last() {
+		return 0, nil
+	}
+	return bytesToUint64(iter.Key()[len("log-"):]), iter.Error()
+}
+
+func logKey(index uint64) []byte {
+	return append([]byte("log-"), uint64ToBytes(index)...)
+}
+
+func (s *LevelDBStore) GetLog(index uint64, log *raft.Log) error {
+	val, err := s.db.Get(logKey(index), nil)
+	if err == leveldb.ErrNotFound {
+		return raft.ErrLogNotFound
+	}
+	if err != nil {
+		return err
+	}
+	return gob.NewDecoder(bytes.NewReader(val)).Decode(log)
+}
+
+func (s *LevelDBStore) StoreLog(log *raft.Log) error {
+	return s.StoreLogs([]*raft.Log{log})
+}
+
+func (s *LevelDBStore) StoreLogs(logs []*raft.Log) error {
+	batch := new(leveldb.Batch)
+	for _, log := range logs {
+		var buf bytes.Buffer
+		if err := gob.NewEncoder(&buf).Encode(log); err != nil {
+			return err
+		}
+		batch.Put(logKey(log.Index), buf.Bytes())
+	}
+	return s.db.Write(batch, nil)
+}
+
+func (s *LevelDBStore) DeleteRange(min, max uint64) error {
+	batch := new(leveldb.Batch)
+	for idx := min; idx <= max; idx++ {
+		batch.Delete(logKey(idx))
+	}
+	return s.db.Write(batch, nil)
+}
+
+func (s *LevelDBStore) Set(key, val []byte) error {
+	return s.db.Put(append([]byte("stable-"), key...), val, nil)
+}
+
+func (s *LevelDBStore) Get(key []byte) ([]byte, error) {
+	val, err := s.db.Get(append([]byte("stable-"), key...), nil)
+	if err == leveldb.ErrNotFound {
+		return nil, nil
+	}
+	return val, err
+}
+
+func (s *LevelDBStore) SetUint64(key []byte, val uint64) error {
+	return s.Set(key, uint64ToBytes(val))
+}
+
+func (s *LevelDBStore) GetUint64(key []byte) (uint64, error) {
+	val, err := s.Get(key)
+	if err != nil || len(val) == 0 {
+		return 0, err
+	}
+	return bytesToUint64(val), nil
+}
+
+// Backup writes every on-disk .ldb/.log/MANIFEST/CURRENT file making up the
+// LevelDB database as a simple length-prefixed stream, so Restore can
+// recreate the directory byte-for-byte.
+func (s *LevelDBStore) Backup(w io.Writer) error {
+	return filepath.Walk(s.path, func(p string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() {
+			return err
+		}
+		rel, err := filepath.Rel(s.path, p)
+		if err != nil {
+			return err
+		}
+		data, err := ioutil.ReadFile(p)
+		if err != nil {
+			return err
+		}
+		if err := writeBackupEntry(w, rel, data); err != nil {
+			return err
+		}
+		return nil
+	})
+}
+
+// Restore replaces the on-disk LevelDB database with the contents of r, as
+// produced by Backup.
+func (s *LevelDBStore) Restore(r io.Reader) error {
+	if err := s.db.Close(); err != nil {
+		return err
+	}
+	if err := os.RemoveAll(s.path); err != nil {
+		return err
+	}
+	if err := os.MkdirAll(s.path, 0700); err != nil {
+		return err
+	}
+	for {
+		rel, data, err := readBackupEntry(r)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		dst := filepath.Join(s.path, rel)
+		if err := os.MkdirAll(filepath.Dir(dst), 0700); err != nil {
+			return err
+		}
+		if err := ioutil.WriteFile(dst, data, 0600); err != nil {
+			return err
+		}
+	}
+	db, err := leveldb.OpenFile(s.path, nil)
+	if err != nil {
+		return err
+	}
+	s.db = db
+	return nil
+}
@@ -32,6 +32,7 @@ import (
 
 	"github.com/armon/go-metrics"
 	metrics_prometheus "github.com/armon/go-metrics/prometheus"
+	"github.com/hashicorp/go-hclog"
 	"github.com/hashicorp/raft"
 	"github.com/stapelberg/glog"
 
@@ -47,6 +48,15 @@ var (
 	raftDir = flag.String("raftdir",
 		"/var/lib/robustirc",
 		"Directory in which raft state is stored. If this directory is empty, you need to specify -join.")
+	storeBackend = flag.String("store_backend",
+		"leveldb",
+		"Storage engine for the raft log and the irc log: one of \"leveldb\" (default, for compatibility with existing installs), \"bolt\" (a single file, easy to back up) or \"inmem\" (tests only, does not survive a restart). The snapshot store is always file-based, independent of this flag.")
+	migrateStore = flag.String("migrate_store",
+		"",
+		"If set to a -store_backend value, converts the raftlog and irclog stores of an existing -raftdir from the backend named by -store_backend to the given one, then exits. The node must not be running while this happens.")
+	panicAction = flag.String("panic_action",
+		"exit",
+		`What to do when an HTTP handler panics: "exit" (default) logs the panic and the full goroutine stack, then calls os.Exit(1) so the init system restarts us with a clean (raft-recovered) in-memory state. "recover" instead falls back to net/http's built-in recover, which merely closes the connection and keeps the (possibly now inconsistent) process running. Only ever set this to "recover" in tests.`)
 	listen = flag.String("listen",
 		":443",
 		"[host]:port to listen on. Set to a port in the dynamic port range (49152 to 65535) and use DNS SRV records.")
@@ -60,6 +70,12 @@ var (
 	join = flag.String("join",
 		"",
 		"host:port of an existing raft node in the network that should be joined. Will also be loaded from -raftdir.")
+	proxy = flag.Bool("proxy",
+		false,
+		"Join the network (requires -join) as a non-voting proxy node: it streams the FSM log and can serve reads and IRC connections, but never participates in elections or quorum and forwards writes to the leader. Use the /promote endpoint to later turn it into a voter.")
+	serverId = flag.String("server_id",
+		"",
+		"Stable, unique identifier for this raft node (a UUID or hostname work well). Defaults to -peer_addr whenever it is not set, but should be set explicitly (and kept the same across restarts) so the node can keep its identity across address changes.")
 	dumpCanaryState = flag.String("dump_canary_state",
 		"",
 		"If specified, initializes the raft node (from a snapshot), then dumps all message state to the specified file. To be used via robustirc-canary.")
@@ -87,8 +103,7 @@ var (
 		"A secure password to protect the communication between raft nodes. Use pwgen(1) or similar. If empty, the ROBUSTIRC_NETWORK_PASSWORD environment variable is used.")
 
 	node      *raft.Raft
-	peerStore *raft.JSONPeers
-	ircStore  *raft_store.LevelDBStore
+	ircStore  raft_store.Store
 	ircServer *ircserver.IRCServer
 
 	// Version is overwritten by Makefile.
@@ -108,6 +123,20 @@ var (
 		},
 	)
 
+	isProxyGauge = prometheus.NewGaugeFunc(
+		prometheus.GaugeOpts{
+			Subsystem: "raft",
+			Name:      "isproxy",
+			Help:      "1 if this node is a non-voting proxy, 0 if it is a voter",
+		},
+		func() float64 {
+			if localSuffrage() == raft.Nonvoter {
+				return 1
+			}
+			return 0
+		},
+	)
+
 	sessionsGauge = prometheus.NewGaugeFunc(
 		prometheus.GaugeOpts{
 			Subsystem: "irc",
@@ -171,6 +200,7 @@ var (
 
 func init() {
 	prometheus.MustRegister(isLeaderGauge)
+	prometheus.MustRegister(isProxyGauge)
 	prometheus.MustRegister(sessionsGauge)
 	prometheus.MustRegister(sessionLimitGauge)
 	prometheus.MustRegister(channelsGauge)
@@ -179,12 +209,18 @@ func init() {
 	prometheus.MustRegister(secondsInState)
 }
 
-func joinMaster(addr string, peerStore *raft.JSONPeers) []string {
+// joinMaster asks the raft node at addr to add us,
+// identified by *serverId/*peerAddr, as a voter (or, if *proxy is set, a
+// non-voting member) via raft.AddVoter/raft.AddNonvoter. It follows HTTP
+// redirects in case addr is not (or no longer) the leader.
+func joinMaster(addr string) {
 	type joinRequest struct {
-		Addr string
+		ID       string
+		Addr     string
+		NonVoter bool
 	}
 	var buf *bytes.Buffer
-	if data, err := json.Marshal(joinRequest{*peerAddr}); err != nil {
+	if data, err := json.Marshal(joinRequest{ID: *serverId, Addr: *peerAddr, NonVoter: *proxy}); err != nil {
 		log.Fatal("Could not marshal join request:", err)
 	} else {
 		buf = bytes.NewBuffer(data)
@@ -211,17 +247,158 @@ func joinMaster(addr string, peerStore *raft.JSONPeers) []string {
 			log.Fatalf("Could not parse redirection %q: %v", loc, err)
 		}
 
-		return joinMaster(u.Host, peerStore)
+		joinMaster(u.Host)
+		return
 	}
 
-	log.Printf("Adding master %q as peer\n", addr)
-	p, err := peerStore.Peers()
+	if *proxy {
+		log.Printf("Added as non-voting proxy by master %q\n", addr)
+	} else {
+		log.Printf("Added as voter by master %q\n", addr)
+	}
+}
+
+// migratePeersToConfiguration upgrades a pre-1.0 hashicorp/raft peers.json
+// (a plain []string of host:port peers) found in raftDir to the new-style
+// configuration.json (a []raft.Server with IDs and suffrage) that
+// raft.BootstrapCluster expects. This lets operators of running clusters
+// upgrade in place instead of dumping and restoring their IRC state, just
+// like rqlite did when it moved from its 4.x to 5.x raft library.
+//
+// It is a no-op when there is no legacy peers.json, or when a
+// configuration.json already exists (i.e. the migration already ran).
+func migratePeersToConfiguration(raftDir, localID, localAddr string) error {
+	oldPath := filepath.Join(raftDir, "peers.json")
+	newPath := filepath.Join(raftDir, "peers", "configuration.json")
+
+	if _, err := os.Stat(newPath); err == nil {
+		return nil // already migrated
+	}
+	if _, err := os.Stat(oldPath); os.IsNotExist(err) {
+		return nil // nothing to migrate
+	}
+
+	configuration, err := raft.ReadPeersJSON(oldPath)
 	if err != nil {
-		log.Fatal("Could not read peers:", err)
+		return fmt.Errorf("reading legacy %q: %v", oldPath, err)
+	}
+
+	// The legacy format only knows host:port, not stable server IDs. Give
+	// this node its configured ID, and fall back to the address for peers
+	// we have never seen a -server_id for.
+	for i, server := range configuration.Servers {
+		if string(server.Address) == localAddr {
+			configuration.Servers[i].ID = raft.ServerID(localID)
+		}
+	}
+
+	if err := os.MkdirAll(filepath.Dir(newPath), 0700); err != nil {
+		return err
+	}
+	var entries []struct {
+		ID       raft.ServerID      `json:"id"`
+		Address  raft.ServerAddress `json:"address"`
+		NonVoter bool               `json:"non_voter"`
+	}
+	for _, server := range configuration.Servers {
+		entries = append(entries, struct {
+			ID       raft.ServerID      `json:"id"`
+			Address  raft.ServerAddress `json:"address"`
+			NonVoter bool               `json:"non_voter"`
+		}{server.ID, server.Address, server.Suffrage != raft.Voter})
+	}
+	data, err := json.MarshalIndent(entries, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(newPath, data, 0600); err != nil {
+		return err
+	}
+	log.Printf("Migrated legacy %q to %q (%d peers)\n", oldPath, newPath, len(entries))
+	return os.Remove(oldPath)
+}
+
+// readLatestConfiguration returns the configuration.json written by
+// migratePeersToConfiguration, if any. It only ever exists for nodes
+// upgraded from a pre-1.0 peers.json; natively-joined nodes carry their
+// configuration in the raft log itself (see hasExistingState in main) and
+// never write this file. When present, its configuration is re-seeded into
+// the otherwise-empty raft log via BootstrapCluster.
+func readLatestConfiguration(raftDir string) (raft.Configuration, bool) {
+	path := filepath.Join(raftDir, "peers", "configuration.json")
+	configuration, err := raft.ReadConfigJSON(path)
+	if err != nil {
+		return raft.Configuration{}, false
+	}
+	return configuration, true
+}
+
+// peerAddresses extracts the host:port addresses from configuration, in the
+// same shape the old raft.JSONPeers.Peers() used to return.
+func peerAddresses(configuration raft.Configuration) []string {
+	addrs := make([]string, 0, len(configuration.Servers))
+	for _, server := range configuration.Servers {
+		addrs = append(addrs, string(server.Address))
+	}
+	return addrs
+}
+
+// migrateStoreBackend converts the "raftlog" and "irclog" stores in raftDir
+// from fromBackend to toBackend offline (the node must not be running
+// concurrently), via raft_store.Migrate. The snapshot store is untouched,
+// since raft.FileSnapshotStore already uses a backend-agnostic on-disk
+// format that every -store_backend can read.
+func migrateStoreBackend(raftDir, fromBackend, toBackend string) error {
+	for _, name := range []string{"raftlog", "irclog"} {
+		dir := filepath.Join(raftDir, name)
+		src, err := raft_store.Open(fromBackend, dir, false)
+		if err != nil {
+			return fmt.Errorf("opening %q as %q: %v", dir, fromBackend, err)
+		}
+		newDir := dir + "." + toBackend
+		dst, err := raft_store.Open(toBackend, newDir, true)
+		if err != nil {
+			src.Close()
+			return fmt.Errorf("opening %q as %q: %v", newDir, toBackend, err)
+		}
+		if err := raft_store.Migrate(src, dst); err != nil {
+			src.Close()
+			dst.Close()
+			return fmt.Errorf("migrating %q: %v", name, err)
+		}
+		src.Close()
+		dst.Close()
+		if err := os.RemoveAll(dir); err != nil {
+			return err
+		}
+		if err := os.Rename(newDir, dir); err != nil {
+			return err
+		}
+		log.Printf("Migrated %q from %q to %q\n", dir, fromBackend, toBackend)
 	}
-	p = raft.AddUniquePeer(p, addr)
-	peerStore.SetPeers(p)
-	return p
+	return nil
+}
+
+// localSuffrage returns this node's current suffrage (Voter or Nonvoter) as
+// seen by the raft log, so that e.g. isProxyGauge reflects the outcome of a
+// /promote call instead of just the -proxy flag this process started with.
+// Before node is initialized, or if we are not (yet) part of the
+// configuration, it reports Voter so a fresh node does not show up as a
+// proxy before it has joined.
+func localSuffrage() raft.ServerSuffrage {
+	if node == nil {
+		return raft.Voter
+	}
+	future := node.GetConfiguration()
+	if err := future.Error(); err != nil {
+		return raft.Voter
+	}
+	for _, server := range future.Configuration().Servers {
+		if server.ID == raft.ServerID(*serverId) {
+			return server.Suffrage
+		}
+	}
+	return raft.Voter
 }
 
 // XXX(1.0): delete this function as users are expected to have upgraded.
@@ -260,6 +437,29 @@ func (ln tcpKeepAliveListener) Accept() (c net.Conn, err error) {
 	return tc, nil
 }
 
+// exitOnRecover wraps next with a recover that, unless -panic_action is
+// "recover", logs the panic and the full goroutine stack via glog.Errorf,
+// flushes glog and calls os.Exit(1). net/http's own built-in recover would
+// otherwise just close the connection and keep serving with whatever
+// in-memory IRC/FSM state the panic left behind; since raft can bring a
+// fresh replica back into consistency, crashing fast and letting the init
+// system restart us is safer than continuing in a half-broken state.
+func exitOnRecover(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		defer func() {
+			if e := recover(); e != nil {
+				if *panicAction == "recover" {
+					panic(e)
+				}
+				glog.Errorf("panic serving %s %s: %v\n%s", r.Method, r.URL.Path, e, debug.Stack())
+				glog.Flush()
+				os.Exit(1)
+			}
+		}()
+		next.ServeHTTP(w, r)
+	})
+}
+
 func printDefault(f *flag.Flag) {
 	format := "  -%s=%s: %s\n"
 	if getter, ok := f.Value.(flag.Getter); ok {
@@ -287,6 +487,7 @@ func main() {
 		fmt.Fprintf(os.Stderr, "The following flags are only relevant when bootstrapping the network (once):\n")
 		printDefault(flag.Lookup("join"))
 		printDefault(flag.Lookup("singlenode"))
+		printDefault(flag.Lookup("proxy"))
 		fmt.Fprintf(os.Stderr, "\n")
 		fmt.Fprintf(os.Stderr, "The following flags are optional:\n")
 		printDefault(flag.Lookup("dump_canary_state"))
@@ -294,6 +495,10 @@ func main() {
 		printDefault(flag.Lookup("canary_compaction_start"))
 		printDefault(flag.Lookup("listen"))
 		printDefault(flag.Lookup("raftdir"))
+		printDefault(flag.Lookup("store_backend"))
+		printDefault(flag.Lookup("migrate_store"))
+		printDefault(flag.Lookup("panic_action"))
+		printDefault(flag.Lookup("server_id"))
 		printDefault(flag.Lookup("tls_ca_file"))
 		printDefault(flag.Lookup("version"))
 		fmt.Fprintf(os.Stderr, "\n")
@@ -323,6 +528,13 @@ func main() {
 		return
 	}
 
+	if *migrateStore != "" {
+		if err := migrateStoreBackend(*raftDir, *storeBackend, *migrateStore); err != nil {
+			log.Fatalf("-migrate_store failed: %v\n", err)
+		}
+		return
+	}
+
 	if _, err := os.Stat(filepath.Join(*raftDir, "deletestate")); err == nil {
 		if err := os.RemoveAll(*raftDir); err != nil {
 			log.Fatal(err)
@@ -354,11 +566,23 @@ func main() {
 		log.Fatalf("-network_name not set, but required.\n")
 	}
 
+	if *proxy && *singleNode {
+		log.Fatalf("-proxy and -singlenode are mutually exclusive.\n")
+	}
+	if *proxy && *join == "" {
+		log.Fatalf("-proxy requires -join (a proxy node always joins an existing network).\n")
+	}
+
 	if *peerAddr == "" {
 		log.Printf("-peer_addr not set, initializing to %q. Make sure %q is a host:port string that other raft nodes can connect to!\n", *listen, *listen)
 		*peerAddr = *listen
 	}
 
+	if *serverId == "" {
+		log.Printf("-server_id not set, defaulting to -peer_addr (%q). Set -server_id explicitly so this node keeps its identity across address changes.\n", *peerAddr)
+		*serverId = *peerAddr
+	}
+
 	ircServer = ircserver.NewIRCServer(*raftDir, *network, time.Now())
 
 	transport := rafthttp.NewHTTPTransport(
@@ -368,18 +592,40 @@ func main() {
 		nil,
 		"")
 
-	peerStore = raft.NewJSONPeers(*raftDir, transport)
+	if err := migratePeersToConfiguration(*raftDir, *serverId, *peerAddr); err != nil {
+		log.Fatalf("Could not migrate legacy peers.json: %v\n", err)
+	}
+
+	existingConfiguration, hasExistingConfiguration := readLatestConfiguration(*raftDir)
+
+	bootstrapping := *singleNode || hasExistingConfiguration
+	logStore, err := raft_store.Open(*storeBackend, filepath.Join(*raftDir, "raftlog"), bootstrapping)
+	if err != nil {
+		log.Fatal(err)
+	}
+	ircStore, err = raft_store.Open(*storeBackend, filepath.Join(*raftDir, "irclog"), bootstrapping)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// hasExistingConfiguration only tells us about a configuration.json
+	// written by migratePeersToConfiguration; it says nothing about a node
+	// that joined natively (via /join) and is now simply restarting. Ask the
+	// raft log itself whether it has ever seen an entry, so such a restart
+	// does not fatal below for lack of -join.
+	lastLogIndex, err := logStore.LastIndex()
+	if err != nil {
+		log.Fatalf("Could not determine raft log state: %v\n", err)
+	}
+	hasExistingState := hasExistingConfiguration || lastLogIndex > 0
 
 	if *join == "" && !*singleNode {
-		peers, err := peerStore.Peers()
-		if err != nil {
-			log.Fatal(err.Error())
-		}
-		if len(peers) == 0 {
+		if !hasExistingState {
 			if !*timesafeguard.DisableTimesafeguard {
-				log.Fatalf("No peers known and -join not specified. Joining the network is not safe because timesafeguard cannot be called.\n")
+				log.Fatalf("No configuration known and -join not specified. Joining the network is not safe because timesafeguard cannot be called.\n")
 			}
-		} else {
+		} else if hasExistingConfiguration {
+			peers := peerAddresses(existingConfiguration)
 			if len(peers) == 1 && peers[0] == *peerAddr {
 				// To prevent crashlooping too frequently in case the init system directly restarts our process.
 				time.Sleep(10 * time.Second)
@@ -389,18 +635,21 @@ func main() {
 				log.Fatal(err.Error())
 			}
 		}
+		// else: a node that already has its own raft log (hasExistingState but
+		// not hasExistingConfiguration) is simply restarting; raft.NewRaft will
+		// restore its configuration (and thus its peers) from that log itself.
 	}
 
-	var p []string
-
 	config := raft.DefaultConfig()
-	config.Logger = log.New(glog.LogBridgeFor("INFO"), "", log.Lshortfile)
-	if *singleNode {
-		config.EnableSingleNode = true
-		config.StartAsLeader = true
-	}
-
-	// Keep 5 snapshots in *raftDir/snapshots, log to stderr.
+	config.Logger = hclog.New(&hclog.LoggerOptions{
+		Output: glog.LogBridgeFor("INFO"),
+		Level:  hclog.Info,
+	})
+	config.LocalID = raft.ServerID(*serverId)
+
+	// Keep 5 snapshots in *raftDir/snapshots, log to stderr. Unlike the raft
+	// log and irc log, the snapshot store is not affected by -store_backend:
+	// raft.FileSnapshotStore's on-disk format is already backend-agnostic.
 	fss, err := raft.NewFileSnapshotStoreWithLogger(*raftDir, 5, config.Logger)
 	if err != nil {
 		log.Fatal(err)
@@ -434,15 +683,6 @@ func main() {
 	}
 	metrics.NewGlobal(metrics.DefaultConfig("raftmetrics"), sink)
 
-	bootstrapping := *singleNode || *join != ""
-	logStore, err := raft_store.NewLevelDBStore(filepath.Join(*raftDir, "raftlog"), bootstrapping)
-	if err != nil {
-		log.Fatal(err)
-	}
-	ircStore, err = raft_store.NewLevelDBStore(filepath.Join(*raftDir, "irclog"), bootstrapping)
-	if err != nil {
-		log.Fatal(err)
-	}
 	fsm := &FSM{
 		store:             logStore,
 		ircstore:          ircStore,
@@ -453,11 +693,30 @@ func main() {
 		log.Fatal(err)
 	}
 
-	node, err = raft.NewRaft(config, fsm, logcache, logStore, fss, peerStore, transport)
+	node, err = raft.NewRaft(config, fsm, logcache, logStore, fss, transport)
 	if err != nil {
 		log.Fatal(err)
 	}
 
+	if *singleNode {
+		f := node.BootstrapCluster(raft.Configuration{
+			Servers: []raft.Server{
+				{Suffrage: raft.Voter, ID: raft.ServerID(*serverId), Address: raft.ServerAddress(*peerAddr)},
+			},
+		})
+		if err := f.Error(); err != nil && err != raft.ErrCantBootstrap {
+			log.Fatalf("BootstrapCluster: %v", err)
+		}
+	} else if hasExistingConfiguration {
+		// Re-seed the configuration we migrated from a pre-1.0 peers.json
+		// (or recovered from disk) so that this node, which was already
+		// part of the network, does not need to -join again.
+		f := node.BootstrapCluster(existingConfiguration)
+		if err := f.Error(); err != nil && err != raft.ErrCantBootstrap {
+			log.Fatalf("BootstrapCluster: %v", err)
+		}
+	}
+
 	if *dumpCanaryState != "" {
 		canary(fsm, *dumpCanaryState)
 		if *dumpHeapProfile != "" {
@@ -482,16 +741,16 @@ func main() {
 	api := api.NewHTTP(
 		ircServer,
 		node,
-		peerStore,
 		ircStore,
 		transport,
 		*network,
 		*networkPassword,
 		*raftDir,
+		*serverId,
 		*peerAddr,
 		http.DefaultServeMux)
 
-	srv := http.Server{Addr: *listen}
+	srv := http.Server{Addr: *listen, Handler: exitOnRecover(http.DefaultServeMux)}
 	if err := http2.ConfigureServer(&srv, nil); err != nil {
 		log.Fatal(err)
 	}
@@ -522,14 +781,10 @@ func main() {
 			log.Fatal(err.Error())
 		}
 
-		p = joinMaster(*join, peerStore)
+		joinMaster(*join)
 		// TODO(secure): properly handle joins on the server-side where the joining node is already in the network.
 	}
 
-	if len(p) > 0 {
-		node.SetPeers(p)
-	}
-
 	expireSessionsTimer := time.After(expireSessionsInterval)
 	secondTicker := time.Tick(1 * time.Second)
 	for {